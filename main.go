@@ -1,41 +1,290 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/time/rate"
 )
 
+// numLatencyBuckets is len(latencyBucketBounds); kept as a separate
+// constant because Go array sizes must be compile-time constants.
+const numLatencyBuckets = 16
+
+// latencyBucketBounds are the upper bounds (in seconds) of the fixed
+// exponential buckets used to track request latency. The last implicit
+// bucket is +Inf.
+var latencyBucketBounds = [numLatencyBuckets]float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05,
+	0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
 type Metrics struct {
 	totalRequests   atomic.Int64
 	totalBodySize   atomic.Int64
 	droppedBodies   atomic.Int64
+	rateLimited     atomic.Int64
 	methodCounts    map[string]*atomic.Int64
 	methodCountsMux sync.RWMutex
+
+	// Latency histogram. latencyBuckets[i] counts samples in
+	// (latencyBucketBounds[i-1], latencyBucketBounds[i]] (non-cumulative);
+	// latencyBuckets[numLatencyBuckets] counts everything else (the +Inf bucket).
+	latencyBuckets   [numLatencyBuckets + 1]atomic.Int64
+	latencyCount     atomic.Int64
+	latencySumBits   atomic.Uint64 // seconds, as math.Float64bits
+	latencySumSqBits atomic.Uint64 // seconds^2, as math.Float64bits
+}
+
+// recordLatency files d into the appropriate bucket and updates the
+// running sum/sum-of-squares, all without taking a lock on the hot path.
+func (m *Metrics) recordLatency(d time.Duration) {
+	seconds := d.Seconds()
+	idx := sort.SearchFloat64s(latencyBucketBounds[:], seconds)
+	m.latencyBuckets[idx].Add(1)
+	m.latencyCount.Add(1)
+	addFloat64(&m.latencySumBits, seconds)
+	addFloat64(&m.latencySumSqBits, seconds*seconds)
+}
+
+// addFloat64 atomically adds delta to the float64 stored in bits via a
+// compare-and-swap retry loop, since there is no native atomic float add.
+func addFloat64(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		newVal := math.Float64bits(math.Float64frombits(old) + delta)
+		if bits.CompareAndSwap(old, newVal) {
+			return
+		}
+	}
+}
+
+// latencyPercentile returns an estimate of the p-th percentile (0 < p < 1)
+// by walking the cumulative bucket counts and returning the upper bound of
+// the bucket that holds the target rank (+Inf if that is the overflow
+// bucket). It does not interpolate within a bucket.
+func (m *Metrics) latencyPercentile(p float64) float64 {
+	total := m.latencyCount.Load()
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p * float64(total)))
+	var cumulative int64
+	for i, bound := range latencyBucketBounds {
+		cumulative += m.latencyBuckets[i].Load()
+		if cumulative >= target {
+			return bound
+		}
+	}
+	return math.Inf(1)
+}
+
+var (
+	requestsTotalDesc = prometheus.NewDesc(
+		"dingdong_requests_total",
+		"Total number of requests received, by HTTP method.",
+		[]string{"method"}, nil,
+	)
+	requestBodyBytesTotalDesc = prometheus.NewDesc(
+		"dingdong_request_body_bytes_total",
+		"Total number of request body bytes processed by the worker pool.",
+		nil, nil,
+	)
+	droppedBodiesTotalDesc = prometheus.NewDesc(
+		"dingdong_dropped_bodies_total",
+		"Total number of request bodies (body chunks under -stream) dropped because the worker queue was full or -max-inflight-bytes would be exceeded.",
+		nil, nil,
+	)
+	rateLimitedTotalDesc = prometheus.NewDesc(
+		"dingdong_rate_limited_total",
+		"Total number of requests rejected with 429 by the rate limiter.",
+		nil, nil,
+	)
+	requestDurationSecondsDesc = prometheus.NewDesc(
+		"dingdong_request_duration_seconds",
+		"Request handling latency in seconds.",
+		nil, nil,
+	)
+	uniqueBodiesDesc = prometheus.NewDesc(
+		"dingdong_unique_bodies",
+		"Number of distinct body digests observed. Only tracked when -dedup is enabled.",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- requestsTotalDesc
+	ch <- requestBodyBytesTotalDesc
+	ch <- droppedBodiesTotalDesc
+	ch <- rateLimitedTotalDesc
+	ch <- requestDurationSecondsDesc
+	ch <- uniqueBodiesDesc
+}
+
+// Collect implements prometheus.Collector, reading straight off the atomic
+// counters so scraping never contends with the hot path.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.methodCountsMux.RLock()
+	for method, counter := range m.methodCounts {
+		ch <- prometheus.MustNewConstMetric(requestsTotalDesc, prometheus.CounterValue, float64(counter.Load()), method)
+	}
+	m.methodCountsMux.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(requestBodyBytesTotalDesc, prometheus.CounterValue, float64(m.totalBodySize.Load()))
+	ch <- prometheus.MustNewConstMetric(droppedBodiesTotalDesc, prometheus.CounterValue, float64(m.droppedBodies.Load()))
+	ch <- prometheus.MustNewConstMetric(rateLimitedTotalDesc, prometheus.CounterValue, float64(m.rateLimited.Load()))
+
+	cumulativeBuckets := make(map[float64]uint64, numLatencyBuckets)
+	var cumulative uint64
+	for i, bound := range latencyBucketBounds {
+		cumulative += uint64(m.latencyBuckets[i].Load())
+		cumulativeBuckets[bound] = cumulative
+	}
+	sum := math.Float64frombits(m.latencySumBits.Load())
+	count := uint64(m.latencyCount.Load())
+	ch <- prometheus.MustNewConstHistogram(requestDurationSecondsDesc, count, sum, cumulativeBuckets)
+
+	if dedupMode {
+		ch <- prometheus.MustNewConstMetric(uniqueBodiesDesc, prometheus.GaugeValue, float64(uniqueBodyCount()))
+	}
 }
 
 type RequestBody struct {
 	body   []byte
 	method string
+
+	// poolBuf is non-nil when body is backed by a buffer drawn from
+	// chunkBufferPool (stream mode) and must be returned after processing.
+	poolBuf *[]byte
+
+	// Populated only when -capture-dir is set, since it's otherwise
+	// unused overhead on the hot path.
+	path       string
+	remoteAddr string
+	headers    map[string]string
+	receivedAt time.Time
 }
 
+// streamChunkSize is the size of the buffers drawn from chunkBufferPool
+// when -stream is enabled.
+const streamChunkSize = 32 * 1024
+
 var (
-	metrics   *Metrics
-	bodyQueue chan RequestBody
+	metrics        *Metrics
+	bodyQueue      chan RequestBody
+	metricsPath    string
+	metricsHandler fasthttp.RequestHandler
+
+	globalLimiter *rate.Limiter
+	ratePerIP     bool
+	ipRate        rate.Limit
+	ipBurst       int
+	ipLimiters    sync.Map // string (remote IP) -> *ipLimiterEntry
+
+	streamMode       bool
+	chunkBufferPool  = sync.Pool{New: func() interface{} { b := make([]byte, streamChunkSize); return &b }}
+	maxInflightBytes int64
+	inflightBytes    atomic.Int64
+
+	captureDir      string
+	captureMaxBytes int64
+
+	dedupMode bool
 )
 
+// numDedupShards is the number of dedup map shards, keyed by the low byte
+// of each xxhash digest (the same sharding-by-low-byte idea, applied where
+// methodCountsMux would otherwise become a contention point).
+const numDedupShards = 256
+
+type dedupEntry struct {
+	count atomic.Int64
+	size  int64
+	path  string // on-disk path, populated only when -capture-dir is also set
+}
+
+type dedupShard struct {
+	mu      sync.RWMutex
+	entries map[uint64]*dedupEntry
+}
+
+var dedupShardsArr [numDedupShards]dedupShard
+
+// recordDedup increments the repeat count for digest, creating its entry on
+// first sight, and remembers the on-disk path once one is known.
+func recordDedup(digest uint64, size int64, path string) {
+	shard := &dedupShardsArr[byte(digest)]
+
+	shard.mu.RLock()
+	entry, exists := shard.entries[digest]
+	shard.mu.RUnlock()
+
+	if !exists {
+		shard.mu.Lock()
+		if entry, exists = shard.entries[digest]; !exists {
+			entry = &dedupEntry{size: size}
+			shard.entries[digest] = entry
+		}
+		shard.mu.Unlock()
+	}
+
+	if path != "" {
+		shard.mu.Lock()
+		if entry.path != path {
+			entry.path = path
+		}
+		shard.mu.Unlock()
+	}
+
+	entry.count.Add(1)
+}
+
+// uniqueBodyCount returns the number of distinct body digests observed so
+// far across all dedup shards.
+func uniqueBodyCount() int {
+	count := 0
+	for i := range dedupShardsArr {
+		shard := &dedupShardsArr[i]
+		shard.mu.RLock()
+		count += len(shard.entries)
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// ipLimiterEntry pairs a per-IP limiter with the last time it was used, so
+// idle entries can be evicted without ever locking ipLimiters for writers.
+type ipLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen atomic.Int64 // unix nano
+}
+
 func init() {
 	metrics = &Metrics{
 		methodCounts: make(map[string]*atomic.Int64),
 	}
+	for i := range dedupShardsArr {
+		dedupShardsArr[i].entries = make(map[uint64]*dedupEntry)
+	}
 }
 
 func main() {
@@ -58,8 +307,79 @@ func main() {
 	workers := flag.Int("w", 25, "Number of worker goroutines")
 	queueSize := flag.Int("q", 10000, "Maximum queue size for body processing")
 	maxBodySizeMB := flag.Int("b", 100, "Maximum request body size in MB")
+	metricsPathFlag := flag.String("metrics-path", "/metrics", "Path to expose Prometheus metrics on")
+	disableMetricsEndpoint := flag.Bool("disable-metrics-endpoint", false, "Disable the Prometheus /metrics endpoint")
+	rateFlag := flag.Float64("rate", 0, "Allowed requests per second (0 disables rate limiting)")
+	burstFlag := flag.Int("burst", 1, "Burst size for the rate limiter")
+	ratePerIPFlag := flag.Bool("rate-per-ip", false, "Apply -rate/-burst per remote IP instead of globally")
+	streamFlag := flag.Bool("stream", false, "Stream request bodies in fixed-size chunks instead of buffering them whole (bounds memory under large-payload load)")
+	maxInflightBytesFlag := flag.Int64("max-inflight-bytes", 0, "Soft cap on bytes currently queued for processing; 0 disables")
+	captureDirFlag := flag.String("capture-dir", "", "Persist each received body under this directory, crash-receiver style (empty disables capture)")
+	captureMaxBytesFlag := flag.Int64("capture-max-bytes", 0, "Soft cap on total bytes under -capture-dir, enforced by evicting the oldest captures; 0 disables eviction")
+	dedupFlag := flag.Bool("dedup", false, "Track repeated bodies by content hash and report the most frequent ones")
 	flag.Parse()
 
+	dedupMode = *dedupFlag
+
+	streamMode = *streamFlag
+	maxInflightBytes = *maxInflightBytesFlag
+
+	if streamMode && dedupMode {
+		// -dedup hashes whole request bodies; under -stream the worker only
+		// ever sees one <=32KB chunk at a time, so it would hash and count
+		// fragments instead of logical bodies. Reject the combination
+		// outright rather than silently reporting meaningless dedup stats.
+		log.Fatalf("-dedup is not supported together with -stream (dedup needs a whole body to hash, streaming never buffers one)")
+	}
+
+	captureDir = *captureDirFlag
+	captureMaxBytes = *captureMaxBytesFlag
+	if captureDir != "" {
+		if streamMode {
+			// streamBody() never populates RequestBody.receivedAt, so the
+			// worker's shouldCapture check would always be false here -
+			// fail fast instead of silently capturing nothing.
+			log.Fatalf("-capture-dir is not supported together with -stream (capture needs a whole body to persist, streaming never buffers one)")
+		}
+		if err := os.MkdirAll(captureDir, 0o755); err != nil {
+			log.Fatalf("capture: cannot create -capture-dir %s: %v", captureDir, err)
+		}
+		evictCaptureDir(captureMaxBytes)
+		if captureMaxBytes > 0 {
+			go func() {
+				ticker := time.NewTicker(5 * time.Minute)
+				defer ticker.Stop()
+				for range ticker.C {
+					evictCaptureDir(captureMaxBytes)
+				}
+			}()
+		}
+	}
+
+	metricsPath = *metricsPathFlag
+	if !*disableMetricsEndpoint {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(metrics)
+		metricsHandler = fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+
+	if *rateFlag > 0 {
+		if *ratePerIPFlag {
+			ratePerIP = true
+			ipRate = rate.Limit(*rateFlag)
+			ipBurst = *burstFlag
+			go evictIdleIPLimiters(10 * time.Minute)
+		} else {
+			globalLimiter = rate.NewLimiter(rate.Limit(*rateFlag), *burstFlag)
+		}
+	} else if *ratePerIPFlag {
+		// -rate-per-ip has no limiter to key without -rate > 0; constructing
+		// rate.NewLimiter(0, 0) per IP would reject every request (burst=0
+		// means Allow() can never succeed), contradicting "0 disables rate
+		// limiting". Leave rate limiting off entirely instead.
+		log.Printf("-rate-per-ip has no effect without -rate > 0; rate limiting stays disabled")
+	}
+
 	// Initialize body queue with configured size
 	bodyQueue = make(chan RequestBody, *queueSize)
 
@@ -84,11 +404,15 @@ func main() {
 		WriteBufferSize:       8192,
 		Concurrency:           256 * 1024,
 		DisableHeaderNamesNormalizing: true,
+		StreamRequestBody:     streamMode,
 	}
 
 	go func() {
 		log.Printf("Starting server on %s", addr)
 		log.Printf("Configuration: workers=%d, queue_size=%d, max_body_size=%dMB", *workers, *queueSize, *maxBodySizeMB)
+		if !*disableMetricsEndpoint {
+			log.Printf("Prometheus metrics exposed on %s", metricsPath)
+		}
 		if err := server.ListenAndServe(addr); err != nil {
 			log.Fatalf("Error in ListenAndServe: %s", err)
 		}
@@ -111,14 +435,72 @@ func main() {
 	displayMetrics()
 }
 
+// allowRequest reports whether ctx should be let through the rate limiter.
+// It is a no-op (always true) unless -rate was configured.
+func allowRequest(ctx *fasthttp.RequestCtx) bool {
+	if ratePerIP {
+		ip := ctx.RemoteIP().String()
+		entryIface, _ := ipLimiters.LoadOrStore(ip, &ipLimiterEntry{limiter: rate.NewLimiter(ipRate, ipBurst)})
+		entry := entryIface.(*ipLimiterEntry)
+		entry.lastSeen.Store(time.Now().UnixNano())
+		return entry.limiter.Allow()
+	}
+	if globalLimiter != nil {
+		return globalLimiter.Allow()
+	}
+	return true
+}
+
+// evictIdleIPLimiters periodically drops per-IP limiters that haven't been
+// touched in idleAfter, so long-running servers don't accumulate one entry
+// per caller forever.
+func evictIdleIPLimiters(idleAfter time.Duration) {
+	ticker := time.NewTicker(idleAfter)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleAfter).UnixNano()
+		ipLimiters.Range(func(key, value interface{}) bool {
+			if value.(*ipLimiterEntry).lastSeen.Load() < cutoff {
+				ipLimiters.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
 func requestHandler(ctx *fasthttp.RequestCtx) {
-	// Respond immediately with 200
-	ctx.SetStatusCode(fasthttp.StatusOK)
+	// Serve Prometheus scrapes directly, bypassing the worker queue entirely.
+	// Excluded from recordLatency too, same as it's excluded from
+	// totalRequests/methodCounts below - a scrape isn't "a request" for
+	// either metric, and timing it would let a scrape's own latency land in
+	// the histogram it just read.
+	if metricsHandler != nil && string(ctx.Path()) == metricsPath {
+		metricsHandler(ctx)
+		return
+	}
+
+	start := time.Now()
+	defer func() { metrics.recordLatency(time.Since(start)) }()
 
 	// Get method and path
 	method := string(ctx.Method())
 	path := string(ctx.Path())
 
+	if (globalLimiter != nil || ratePerIP) && !allowRequest(ctx) {
+		ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+		metrics.rateLimited.Add(1)
+		// A rejected request is still a request: count it so displayMetrics()
+		// and the Prometheus counters reflect total traffic, not just what
+		// made it past the limiter.
+		metrics.totalRequests.Add(1)
+		incrementMethodCount(method)
+		return
+	}
+
+	// Respond immediately with 200
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
 	// Dump request to console if path contains "dump"
 	if strings.Contains(path, "dump") {
 		fmt.Println(strings.Repeat("-", 50))
@@ -130,28 +512,40 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 		ctx.Request.Header.VisitAll(func(key, value []byte) {
 			fmt.Printf("  %s: %s\n", string(key), string(value))
 		})
-		bodyLen := len(ctx.Request.Body())
-		fmt.Printf("Body Size:   %d bytes\n", bodyLen)
-		if bodyLen > 0 && bodyLen <= 1024 {
-			fmt.Printf("Body:        %s\n", string(ctx.Request.Body()))
-		} else if bodyLen > 1024 {
-			fmt.Printf("Body:        [%d bytes - too large to display]\n", bodyLen)
+		if streamMode {
+			// ctx.Request.Body() would drain bodyStream into memory and close
+			// it, defeating -stream entirely and starving streamBody() below.
+			fmt.Printf("Body:        [streaming - not buffered for dump]\n")
+		} else {
+			bodyLen := len(ctx.Request.Body())
+			fmt.Printf("Body Size:   %d bytes\n", bodyLen)
+			if bodyLen > 0 && bodyLen <= 1024 {
+				fmt.Printf("Body:        %s\n", string(ctx.Request.Body()))
+			} else if bodyLen > 1024 {
+				fmt.Printf("Body:        [%d bytes - too large to display]\n", bodyLen)
+			}
 		}
 		fmt.Println(strings.Repeat("-", 50))
 	}
 
-	// Copy body for async processing
-	bodyLen := len(ctx.Request.Body())
-	if bodyLen > 0 {
-		bodyCopy := make([]byte, bodyLen)
-		copy(bodyCopy, ctx.Request.Body())
-
-		// Send to worker pool (non-blocking)
-		select {
-		case bodyQueue <- RequestBody{body: bodyCopy, method: method}:
-		default:
-			// Queue full, skip this body but still count the request
-			metrics.droppedBodies.Add(1)
+	// Hand the body off for async processing, either streamed in fixed-size
+	// chunks (bounded memory) or fully buffered (the default).
+	if streamMode {
+		streamBody(ctx, method)
+	} else {
+		bodyLen := len(ctx.Request.Body())
+		if bodyLen > 0 {
+			bodyCopy := make([]byte, bodyLen)
+			copy(bodyCopy, ctx.Request.Body())
+
+			rb := RequestBody{body: bodyCopy, method: method}
+			if captureDir != "" {
+				rb.path = path
+				rb.remoteAddr = ctx.RemoteAddr().String()
+				rb.headers = cloneHeaders(ctx)
+				rb.receivedAt = time.Now()
+			}
+			tryEnqueue(rb, int64(bodyLen))
 		}
 	}
 
@@ -160,6 +554,52 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 	incrementMethodCount(method)
 }
 
+// streamBody reads the request body via fasthttp's streaming reader in
+// fixed-size chunks drawn from chunkBufferPool, so a single large payload
+// never needs to be materialized in full.
+func streamBody(ctx *fasthttp.RequestCtx, method string) {
+	reader := ctx.RequestBodyStream()
+	if reader == nil {
+		return
+	}
+
+	for {
+		bufPtr := chunkBufferPool.Get().(*[]byte)
+		n, err := io.ReadFull(reader, *bufPtr)
+		if n > 0 {
+			chunk := (*bufPtr)[:n]
+			if !tryEnqueue(RequestBody{body: chunk, method: method, poolBuf: bufPtr}, int64(n)) {
+				chunkBufferPool.Put(bufPtr)
+			}
+		} else {
+			chunkBufferPool.Put(bufPtr)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// tryEnqueue queues rb for worker processing, honoring -max-inflight-bytes
+// and the existing drop-on-full-queue behavior. It reports whether rb was
+// accepted, so callers owning a pooled buffer know whether to release it.
+func tryEnqueue(rb RequestBody, size int64) bool {
+	if maxInflightBytes > 0 && inflightBytes.Load()+size > maxInflightBytes {
+		metrics.droppedBodies.Add(1)
+		return false
+	}
+
+	select {
+	case bodyQueue <- rb:
+		inflightBytes.Add(size)
+		return true
+	default:
+		// Queue full, skip this body but still count the request
+		metrics.droppedBodies.Add(1)
+		return false
+	}
+}
+
 func worker(wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -167,6 +607,137 @@ func worker(wg *sync.WaitGroup) {
 		// Process body - calculate size and update metrics
 		bodySize := int64(len(rb.body))
 		metrics.totalBodySize.Add(bodySize)
+		inflightBytes.Add(-bodySize)
+
+		shouldCapture := captureDir != "" && !rb.receivedAt.IsZero()
+		if shouldCapture || dedupMode {
+			digest := xxhash.Sum64(rb.body)
+
+			var capturedPath string
+			if shouldCapture {
+				capturedPath = captureBody(rb, digest)
+			}
+			if dedupMode {
+				recordDedup(digest, bodySize, capturedPath)
+			}
+		}
+
+		if rb.poolBuf != nil {
+			chunkBufferPool.Put(rb.poolBuf)
+		}
+	}
+}
+
+// cloneHeaders snapshots the request headers into a plain map so they
+// survive past the fasthttp request's lifetime, for sidecar capture files.
+func cloneHeaders(ctx *fasthttp.RequestCtx) map[string]string {
+	headers := make(map[string]string)
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		headers[string(key)] = string(value)
+	})
+	return headers
+}
+
+// captureSidecar is the JSON sidecar written alongside each captured body.
+type captureSidecar struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	RemoteAddr string            `json:"remote_addr"`
+	Headers    map[string]string `json:"headers"`
+	Timestamp  time.Time         `json:"timestamp"`
+	SizeBytes  int               `json:"size_bytes"`
+}
+
+// captureBody persists rb under <capture-dir>/<YYYY>/<MM>/<DD>/<hash>.bin
+// with a JSON sidecar, using digest as the filename so identical bodies
+// received on the same day coalesce to a single copy on disk (each day's
+// directory gets its own copy). It returns the path of the body file,
+// whether newly written or already present.
+func captureBody(rb RequestBody, digest uint64) string {
+	dir := filepath.Join(captureDir,
+		rb.receivedAt.Format("2006"), rb.receivedAt.Format("01"), rb.receivedAt.Format("02"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("capture: mkdir %s: %v", dir, err)
+		return ""
+	}
+
+	base := fmt.Sprintf("%016x", digest)
+	bodyPath := filepath.Join(dir, base+".bin")
+	sidecarPath := filepath.Join(dir, base+".json")
+
+	if _, err := os.Stat(bodyPath); err == nil {
+		// Identical body already captured; nothing to do.
+		return bodyPath
+	}
+
+	if err := os.WriteFile(bodyPath, rb.body, 0o644); err != nil {
+		log.Printf("capture: write %s: %v", bodyPath, err)
+		return ""
+	}
+
+	sidecar := captureSidecar{
+		Method:     rb.method,
+		Path:       rb.path,
+		RemoteAddr: rb.remoteAddr,
+		Headers:    rb.headers,
+		Timestamp:  rb.receivedAt,
+		SizeBytes:  len(rb.body),
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		log.Printf("capture: marshal sidecar for %s: %v", bodyPath, err)
+		return bodyPath
+	}
+	if err := os.WriteFile(sidecarPath, data, 0o644); err != nil {
+		log.Printf("capture: write %s: %v", sidecarPath, err)
+	}
+	return bodyPath
+}
+
+// evictCaptureDir enforces -capture-max-bytes by deleting the oldest
+// captures (by file modification time) until the directory is back under
+// the cap. Run once at startup and then on a periodic sweep.
+func evictCaptureDir(maxBytes int64) {
+	if captureDir == "" || maxBytes <= 0 {
+		return
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+
+	filepath.Walk(captureDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		total += info.Size()
+		if strings.HasSuffix(p, ".bin") {
+			entries = append(entries, entry{path: p, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+
+	if total <= maxBytes {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		os.Remove(e.path)
+		sidecarPath := strings.TrimSuffix(e.path, ".bin") + ".json"
+		if info, err := os.Stat(sidecarPath); err == nil {
+			total -= info.Size()
+		}
+		os.Remove(sidecarPath)
+		total -= e.size
 	}
 }
 
@@ -213,6 +784,14 @@ func displayMetrics() {
 	}
 	fmt.Println()
 
+	rateLimited := metrics.rateLimited.Load()
+	fmt.Printf("Rate Limited:       %d", rateLimited)
+	if rateLimited > 0 {
+		rateLimitedPercentage := float64(rateLimited) / float64(totalReqs) * 100
+		fmt.Printf(" (%.2f%% of requests)", rateLimitedPercentage)
+	}
+	fmt.Println()
+
 	fmt.Println("\nRequests by Method:")
 	metrics.methodCountsMux.RLock()
 	for method, counter := range metrics.methodCounts {
@@ -222,5 +801,143 @@ func displayMetrics() {
 	}
 	metrics.methodCountsMux.RUnlock()
 
+	displayLatency()
+
+	if dedupMode {
+		displayDedup()
+	}
+
 	fmt.Println(strings.Repeat("=", 50))
 }
+
+// displayDedup prints the most frequently repeated bodies observed, plus
+// the total count of distinct bodies seen.
+func displayDedup() {
+	type row struct {
+		digest uint64
+		count  int64
+		size   int64
+		path   string
+	}
+
+	var rows []row
+	for i := range dedupShardsArr {
+		shard := &dedupShardsArr[i]
+		shard.mu.RLock()
+		for digest, entry := range shard.entries {
+			rows = append(rows, row{digest: digest, count: entry.count.Load(), size: entry.size, path: entry.path})
+		}
+		shard.mu.RUnlock()
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].count > rows[j].count })
+
+	fmt.Println("\nTop Repeated Bodies:")
+	const topN = 10
+	shown := 0
+	for _, r := range rows {
+		if shown >= topN {
+			break
+		}
+		if r.count < 2 {
+			continue
+		}
+		if r.path != "" {
+			fmt.Printf("  %016x  count=%-6d size=%-8d path=%s\n", r.digest, r.count, r.size, r.path)
+		} else {
+			fmt.Printf("  %016x  count=%-6d size=%d\n", r.digest, r.count, r.size)
+		}
+		shown++
+	}
+	if shown == 0 {
+		fmt.Println("  no repeated bodies observed")
+	}
+
+	fmt.Printf("\nUnique Bodies: %d\n", len(rows))
+}
+
+// displayLatency prints count/min/max/mean/percentiles and a small ASCII
+// histogram derived from the fixed latency buckets.
+func displayLatency() {
+	count := metrics.latencyCount.Load()
+	fmt.Println("\nRequest Latency:")
+	if count == 0 {
+		fmt.Println("  no samples recorded")
+		return
+	}
+
+	sum := math.Float64frombits(metrics.latencySumBits.Load())
+	sumSq := math.Float64frombits(metrics.latencySumSqBits.Load())
+	mean := sum / float64(count)
+	variance := sumSq/float64(count) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+
+	var min, max float64 = -1, -1
+	for i := 0; i <= numLatencyBuckets; i++ {
+		if metrics.latencyBuckets[i].Load() == 0 {
+			continue
+		}
+		if min < 0 {
+			if i < numLatencyBuckets {
+				min = latencyBucketBounds[i]
+			} else {
+				min = latencyBucketBounds[numLatencyBuckets-1]
+			}
+		}
+		if i < numLatencyBuckets {
+			max = latencyBucketBounds[i]
+		} else {
+			max = math.Inf(1)
+		}
+	}
+
+	fmt.Printf("  Count:  %d\n", count)
+	fmt.Printf("  Min:    %s\n", formatLatency(min))
+	fmt.Printf("  Max:    %s\n", formatLatency(max))
+	fmt.Printf("  Mean:   %s (stddev %s)\n", formatLatency(mean), formatLatency(math.Sqrt(variance)))
+	fmt.Printf("  p50:    %s\n", formatLatency(metrics.latencyPercentile(0.50)))
+	fmt.Printf("  p90:    %s\n", formatLatency(metrics.latencyPercentile(0.90)))
+	fmt.Printf("  p99:    %s\n", formatLatency(metrics.latencyPercentile(0.99)))
+	fmt.Printf("  p99.9:  %s\n", formatLatency(metrics.latencyPercentile(0.999)))
+
+	fmt.Println("\n  Histogram:")
+	var maxCount int64
+	for i := 0; i <= numLatencyBuckets; i++ {
+		if c := metrics.latencyBuckets[i].Load(); c > maxCount {
+			maxCount = c
+		}
+	}
+	for i := 0; i <= numLatencyBuckets; i++ {
+		c := metrics.latencyBuckets[i].Load()
+		if c == 0 {
+			continue
+		}
+		label := "+Inf"
+		if i < numLatencyBuckets {
+			label = formatLatency(latencyBucketBounds[i])
+		}
+		barLen := 0
+		if maxCount > 0 {
+			barLen = int(float64(c) / float64(maxCount) * 40)
+		}
+		fmt.Printf("  %8s | %-40s %d\n", label, strings.Repeat("#", barLen), c)
+	}
+}
+
+func formatLatency(seconds float64) string {
+	if math.IsInf(seconds, 1) {
+		return "+Inf"
+	}
+	switch {
+	case seconds < 0:
+		return "n/a"
+	case seconds < 1e-3:
+		return fmt.Sprintf("%.3fms", seconds*1000)
+	case seconds < 1:
+		return fmt.Sprintf("%.2fms", seconds*1000)
+	default:
+		return fmt.Sprintf("%.3fs", seconds)
+	}
+}